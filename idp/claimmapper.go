@@ -0,0 +1,168 @@
+// Copyright 2017 Canonical Ltd.
+
+package idp
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// ClaimMapper transforms the attributes or claims an identity
+// provider receives from its upstream (an OIDC ID token, an LDAP
+// entry's attributes, Ubuntu SSO's registered account details, ...)
+// into the set of Candid groups a user should be a member of.
+//
+// Different upstreams expose group or role membership under
+// different claim names ("groups" for Keycloak, "roles" for ADFS,
+// and so on) so the mapping is configured per identity provider
+// rather than hard-coded.
+type ClaimMapper struct {
+	// Rules is the ordered list of rules used to derive Candid
+	// groups from claims. Every rule that matches contributes its
+	// groups; rules are not mutually exclusive.
+	Rules []ClaimRule `yaml:"rules"`
+
+	// AdminGroups lists the Candid groups that, if present in the
+	// set derived from Rules, also grant the Candid admin ACL.
+	AdminGroups []string `yaml:"admin_groups"`
+}
+
+// ClaimRule is a single rule of a ClaimMapper. A rule either extracts
+// group names from a claim's value (optionally filtering by Prefix
+// and transforming with Rename), or, when Equals is set, tests a
+// claim's value for equality and adds a fixed set of groups.
+type ClaimRule struct {
+	// Claim is the name of the claim the rule reads. The synthetic
+	// claim "email_domain" is derived automatically from the
+	// "email" claim, for rules that key off the user's email
+	// domain.
+	Claim string `yaml:"claim"`
+
+	// Prefix, if set, restricts the rule to claim values that have
+	// this prefix, which is stripped before the value is used as a
+	// Candid group name.
+	Prefix string `yaml:"prefix,omitempty"`
+
+	// Equals, if set, changes the rule from a group-extraction rule
+	// to a condition: AddGroups is only added when the named claim
+	// has exactly this value.
+	Equals string `yaml:"equals,omitempty"`
+
+	// AddGroups is the set of Candid groups to add when Equals
+	// matches.
+	AddGroups []string `yaml:"add_groups,omitempty"`
+
+	// Rename, if set, is applied to every extracted group name as
+	// regexp.ReplaceAllString(value, Rename.Replace).
+	Rename *ClaimRename `yaml:"rename,omitempty"`
+
+	rename *regexp.Regexp
+}
+
+// ClaimRename renames group names extracted from a claim using a
+// regular expression.
+type ClaimRename struct {
+	Pattern string `yaml:"pattern"`
+	Replace string `yaml:"replace"`
+}
+
+// Compile validates m's rules and compiles their regular expressions.
+// It must be called once after a ClaimMapper is unmarshalled from
+// configuration and before Groups is used.
+func (m *ClaimMapper) Compile() error {
+	for i := range m.Rules {
+		r := &m.Rules[i]
+		if r.Claim == "" {
+			return errgo.Newf("claim mapping rule %d has no claim", i)
+		}
+		if r.Rename == nil {
+			continue
+		}
+		re, err := regexp.Compile(r.Rename.Pattern)
+		if err != nil {
+			return errgo.Notef(err, "invalid rename pattern for claim %q", r.Claim)
+		}
+		r.rename = re
+	}
+	return nil
+}
+
+// Groups derives the set of Candid groups a user should be a member
+// of from claims, and reports whether membership of those groups
+// grants the Candid admin ACL. claims maps claim names to their
+// values, which may be a string or a slice of strings.
+func (m *ClaimMapper) Groups(claims map[string]interface{}) (groups []string, isAdmin bool) {
+	seen := make(map[string]bool)
+	add := func(g string) {
+		if g == "" || seen[g] {
+			return
+		}
+		seen[g] = true
+		groups = append(groups, g)
+	}
+	for _, r := range m.Rules {
+		values := claimValues(claims, r.Claim)
+		if r.Equals != "" {
+			for _, v := range values {
+				if v == r.Equals {
+					for _, g := range r.AddGroups {
+						add(g)
+					}
+					break
+				}
+			}
+			continue
+		}
+		for _, v := range values {
+			if r.Prefix != "" {
+				if !strings.HasPrefix(v, r.Prefix) {
+					continue
+				}
+				v = strings.TrimPrefix(v, r.Prefix)
+			}
+			if r.rename != nil {
+				v = r.rename.ReplaceAllString(v, r.Rename.Replace)
+			}
+			add(v)
+		}
+	}
+	for _, g := range groups {
+		for _, a := range m.AdminGroups {
+			if g == a {
+				return groups, true
+			}
+		}
+	}
+	return groups, false
+}
+
+// claimValues returns the values of the named claim as a slice of
+// strings, synthesizing the "email_domain" claim from "email" when
+// needed. It understands string, []string and []interface{} claim
+// values; anything else is ignored.
+func claimValues(claims map[string]interface{}, name string) []string {
+	if name == "email_domain" {
+		email, _ := claims["email"].(string)
+		if i := strings.LastIndex(email, "@"); i >= 0 {
+			return []string{email[i+1:]}
+		}
+		return nil
+	}
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		vs := make([]string, 0, len(v))
+		for _, e := range v {
+			vs = append(vs, fmt.Sprint(e))
+		}
+		return vs
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 Canonical Ltd.
+
+package idp
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func groupsOf(t *testing.T, m *ClaimMapper, claims map[string]interface{}) ([]string, bool) {
+	t.Helper()
+	if err := m.Compile(); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	groups, isAdmin := m.Groups(claims)
+	sort.Strings(groups)
+	return groups, isAdmin
+}
+
+func TestClaimMapperPrefix(t *testing.T) {
+	m := &ClaimMapper{
+		Rules: []ClaimRule{{
+			Claim:  "groups",
+			Prefix: "candid-",
+		}},
+	}
+	groups, isAdmin := groupsOf(t, m, map[string]interface{}{
+		"groups": []interface{}{"candid-admins", "other-group"},
+	})
+	if isAdmin {
+		t.Fatalf("expected isAdmin to be false")
+	}
+	if want := []string{"admins"}; !reflect.DeepEqual(groups, want) {
+		t.Fatalf("got groups %v, want %v", groups, want)
+	}
+}
+
+func TestClaimMapperEqualsAddGroups(t *testing.T) {
+	m := &ClaimMapper{
+		Rules: []ClaimRule{{
+			Claim:     "department",
+			Equals:    "engineering",
+			AddGroups: []string{"eng", "staff"},
+		}},
+	}
+	groups, _ := groupsOf(t, m, map[string]interface{}{
+		"department": "engineering",
+	})
+	if want := []string{"eng", "staff"}; !reflect.DeepEqual(groups, want) {
+		t.Fatalf("got groups %v, want %v", groups, want)
+	}
+
+	groups, _ = groupsOf(t, m, map[string]interface{}{
+		"department": "sales",
+	})
+	if len(groups) != 0 {
+		t.Fatalf("expected no groups for non-matching Equals, got %v", groups)
+	}
+}
+
+func TestClaimMapperRename(t *testing.T) {
+	m := &ClaimMapper{
+		Rules: []ClaimRule{{
+			Claim: "roles",
+			Rename: &ClaimRename{
+				Pattern: "^role-(.*)$",
+				Replace: "$1",
+			},
+		}},
+	}
+	groups, _ := groupsOf(t, m, map[string]interface{}{
+		"roles": []interface{}{"role-admin", "role-user"},
+	})
+	if want := []string{"admin", "user"}; !reflect.DeepEqual(groups, want) {
+		t.Fatalf("got groups %v, want %v", groups, want)
+	}
+}
+
+func TestClaimMapperAdminGroups(t *testing.T) {
+	m := &ClaimMapper{
+		Rules: []ClaimRule{{
+			Claim:  "groups",
+			Prefix: "candid-",
+		}},
+		AdminGroups: []string{"admins"},
+	}
+	_, isAdmin := groupsOf(t, m, map[string]interface{}{
+		"groups": []interface{}{"candid-admins"},
+	})
+	if !isAdmin {
+		t.Fatalf("expected isAdmin to be true")
+	}
+
+	_, isAdmin = groupsOf(t, m, map[string]interface{}{
+		"groups": []interface{}{"candid-users"},
+	})
+	if isAdmin {
+		t.Fatalf("expected isAdmin to be false")
+	}
+}
+
+func TestClaimMapperEmailDomain(t *testing.T) {
+	m := &ClaimMapper{
+		Rules: []ClaimRule{{
+			Claim:  "email_domain",
+			Equals: "example.com",
+			AddGroups: []string{
+				"employees",
+			},
+		}},
+	}
+	groups, _ := groupsOf(t, m, map[string]interface{}{
+		"email": "alice@example.com",
+	})
+	if want := []string{"employees"}; !reflect.DeepEqual(groups, want) {
+		t.Fatalf("got groups %v, want %v", groups, want)
+	}
+
+	groups, _ = groupsOf(t, m, map[string]interface{}{
+		"email": "alice@other.com",
+	})
+	if len(groups) != 0 {
+		t.Fatalf("expected no groups for non-matching email domain, got %v", groups)
+	}
+}
+
+func TestClaimMapperDeduplicatesGroups(t *testing.T) {
+	m := &ClaimMapper{
+		Rules: []ClaimRule{
+			{
+				Claim:  "groups",
+				Prefix: "candid-",
+			},
+			{
+				Claim:     "department",
+				Equals:    "engineering",
+				AddGroups: []string{"admins"},
+			},
+		},
+	}
+	groups, _ := groupsOf(t, m, map[string]interface{}{
+		"groups":     []interface{}{"candid-admins"},
+		"department": "engineering",
+	})
+	if want := []string{"admins"}; !reflect.DeepEqual(groups, want) {
+		t.Fatalf("got groups %v, want %v", groups, want)
+	}
+}
+
+func TestClaimMapperCompileRejectsMissingClaim(t *testing.T) {
+	m := &ClaimMapper{
+		Rules: []ClaimRule{{}},
+	}
+	if err := m.Compile(); err == nil {
+		t.Fatal("expected an error for a rule with no claim")
+	}
+}
+
+func TestClaimMapperCompileRejectsInvalidRenamePattern(t *testing.T) {
+	m := &ClaimMapper{
+		Rules: []ClaimRule{{
+			Claim: "groups",
+			Rename: &ClaimRename{
+				Pattern: "(",
+			},
+		}},
+	}
+	if err := m.Compile(); err == nil {
+		t.Fatal("expected an error for an invalid rename pattern")
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 Canonical Ltd.
+
+package oidc
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// discoveryDoc holds the subset of an OpenID Connect discovery
+// document (RFC: "OpenID Connect Discovery 1.0") that Candid needs in
+// order to drive the authorization code flow and verify ID tokens.
+type discoveryDoc struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// discoveryTTL controls how long a fetched discovery document is
+// cached before being refetched.
+const discoveryTTL = time.Hour
+
+// discoveryDoc returns the provider's cached discovery document,
+// fetching it from IssuerURL + "/.well-known/openid-configuration" if
+// it has not been fetched yet, or if the cached copy is older than
+// discoveryTTL, so that a provider that changes its endpoints or
+// jwks_uri is picked up without a Candid restart.
+func (idp *identityProvider) discoveryDoc() (*discoveryDoc, error) {
+	idp.mu.Lock()
+	d := idp.discovery
+	fresh := d != nil && time.Since(idp.discoveryFetchedAt) < discoveryTTL
+	idp.mu.Unlock()
+	if fresh {
+		return d, nil
+	}
+	fetched, err := fetchDiscoveryDoc(idp.params.IssuerURL)
+	if err != nil {
+		if d != nil {
+			// Fall back to the stale document rather than
+			// breaking every login because the provider is
+			// temporarily unreachable.
+			return d, nil
+		}
+		return nil, errgo.Mask(err)
+	}
+	idp.mu.Lock()
+	idp.discovery = fetched
+	idp.discoveryFetchedAt = time.Now()
+	idp.mu.Unlock()
+	return fetched, nil
+}
+
+func fetchDiscoveryDoc(issuerURL string) (*discoveryDoc, error) {
+	resp, err := httpClient.Get(issuerURL + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot fetch openid-configuration")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errgo.Newf("cannot fetch openid-configuration: unexpected status %q", resp.Status)
+	}
+	var d discoveryDoc
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, errgo.Notef(err, "cannot decode openid-configuration")
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errgo.Newf("openid-configuration is missing required endpoints")
+	}
+	return &d, nil
+}
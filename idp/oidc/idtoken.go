@@ -0,0 +1,130 @@
+// Copyright 2017 Canonical Ltd.
+
+package oidc
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// idTokenClaims holds the subset of ID token claims that Candid uses
+// to identify and provision a user.
+type idTokenClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	Expiry            int64    `json:"exp"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	Groups            []string `json:"groups"`
+
+	// Raw holds the decoded claim set as a generic map, for use
+	// with an idp.ClaimMapper, which may need to read claims this
+	// struct does not name explicitly (custom role claims, and so
+	// on).
+	Raw map[string]interface{} `json:"-"`
+}
+
+// audience accepts the "aud" claim, which per RFC 7519 may be encoded
+// as either a single string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(data, &ss); err != nil {
+		return err
+	}
+	*a = audience(ss)
+	return nil
+}
+
+func (a audience) contains(v string) bool {
+	for _, s := range a {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+type jwsHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verifyIDToken verifies the JWS signature of the given compact-form
+// ID token against the provider's JWKS, then validates the standard
+// iss/aud/exp claims and the nonce against the value generated for
+// the login attempt.
+func (idp *identityProvider) verifyIDToken(rawToken, wantNonce string) (*idTokenClaims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, errgo.Newf("id token is not a well-formed JWS")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid id token header")
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, errgo.Notef(err, "invalid id token header")
+	}
+	if header.Alg != "RS256" {
+		return nil, errgo.Newf("unsupported id token signature algorithm %q", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid id token signature")
+	}
+	key, err := idp.key(header.Kid)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot find verification key")
+	}
+	signed := parts[0] + "." + parts[1]
+	digest := sha256.Sum256([]byte(signed))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, errgo.Newf("id token signature verification failed")
+	}
+	payloadJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid id token payload")
+	}
+	var claims idTokenClaims
+	if err := json.Unmarshal(payloadJSON, &claims); err != nil {
+		return nil, errgo.Notef(err, "invalid id token payload")
+	}
+	if err := json.Unmarshal(payloadJSON, &claims.Raw); err != nil {
+		return nil, errgo.Notef(err, "invalid id token payload")
+	}
+	d, err := idp.discoveryDoc()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if claims.Issuer != d.Issuer && claims.Issuer != idp.params.IssuerURL {
+		return nil, errgo.Newf("unexpected issuer %q", claims.Issuer)
+	}
+	if !claims.Audience.contains(idp.params.ClientID) {
+		return nil, errgo.Newf("id token is not for this client")
+	}
+	if time.Now().After(time.Unix(claims.Expiry, 0)) {
+		return nil, errgo.Newf("id token has expired")
+	}
+	if claims.Nonce != wantNonce {
+		return nil, errgo.Newf("id token nonce does not match")
+	}
+	return &claims, nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 Canonical Ltd.
+
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func testIdentityProvider(t *testing.T, key *rsa.PrivateKey, kid string) *identityProvider {
+	t.Helper()
+	idp := &identityProvider{
+		params: Params{
+			IssuerURL: "https://issuer.example.com",
+			ClientID:  "candid",
+		},
+		discovery: &discoveryDoc{
+			Issuer:                "https://issuer.example.com",
+			AuthorizationEndpoint: "https://issuer.example.com/auth",
+			TokenEndpoint:         "https://issuer.example.com/token",
+			JWKSURI:               "https://issuer.example.com/jwks",
+		},
+		discoveryFetchedAt: time.Now(),
+		keys: &jwksCache{
+			uri:       "https://issuer.example.com/jwks",
+			fetchedAt: time.Now(),
+			keys:      map[string]*rsa.PublicKey{kid: &key.PublicKey},
+		},
+		states: make(map[string]stateEntry),
+	}
+	return idp
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header := map[string]string{"alg": "RS256", "kid": kid}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." +
+		base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifyIDTokenAcceptsValidToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := testIdentityProvider(t, key, "kid1")
+	now := time.Now()
+	tok := signToken(t, key, "kid1", map[string]interface{}{
+		"iss":   "https://issuer.example.com",
+		"sub":   "alice",
+		"aud":   "candid",
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": "thenonce",
+		"email": "alice@example.com",
+	})
+	claims, err := idp.verifyIDToken(tok, "thenonce")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims.Subject != "alice" {
+		t.Fatalf("unexpected subject %q", claims.Subject)
+	}
+}
+
+func TestVerifyIDTokenRejectsBadSignature(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := testIdentityProvider(t, key, "kid1")
+	now := time.Now()
+	// Signed with the wrong key, but claiming to be "kid1".
+	tok := signToken(t, otherKey, "kid1", map[string]interface{}{
+		"iss":   "https://issuer.example.com",
+		"sub":   "alice",
+		"aud":   "candid",
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": "thenonce",
+	})
+	if _, err := idp.verifyIDToken(tok, "thenonce"); err == nil {
+		t.Fatal("expected signature verification to fail")
+	}
+}
+
+func TestVerifyIDTokenRejectsWrongNonce(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := testIdentityProvider(t, key, "kid1")
+	now := time.Now()
+	tok := signToken(t, key, "kid1", map[string]interface{}{
+		"iss":   "https://issuer.example.com",
+		"sub":   "alice",
+		"aud":   "candid",
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": "thenonce",
+	})
+	if _, err := idp.verifyIDToken(tok, "othernonce"); err == nil {
+		t.Fatal("expected nonce mismatch to be rejected")
+	}
+}
+
+func TestVerifyIDTokenRejectsExpiredToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := testIdentityProvider(t, key, "kid1")
+	tok := signToken(t, key, "kid1", map[string]interface{}{
+		"iss":   "https://issuer.example.com",
+		"sub":   "alice",
+		"aud":   "candid",
+		"exp":   time.Now().Add(-time.Hour).Unix(),
+		"nonce": "thenonce",
+	})
+	if _, err := idp.verifyIDToken(tok, "thenonce"); err == nil {
+		t.Fatal("expected expired token to be rejected")
+	}
+}
+
+func TestVerifyIDTokenRejectsWrongAudience(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := testIdentityProvider(t, key, "kid1")
+	tok := signToken(t, key, "kid1", map[string]interface{}{
+		"iss":   "https://issuer.example.com",
+		"sub":   "alice",
+		"aud":   "someone-else",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": "thenonce",
+	})
+	if _, err := idp.verifyIDToken(tok, "thenonce"); err == nil {
+		t.Fatal("expected audience mismatch to be rejected")
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 Canonical Ltd.
+
+package oidc
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// jwksRefreshInterval is how often a provider's JWKS document is
+// refetched, so that key rotation on the identity provider side is
+// picked up without requiring a Candid restart.
+const jwksRefreshInterval = 15 * time.Minute
+
+// jwk holds the fields of a JSON Web Key that are needed to
+// reconstruct an RSA public key, as used for RS256 ID token
+// signatures. Other key types are not currently supported.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+// jwksCache holds the most recently fetched set of RSA public keys
+// for a provider, keyed by key id, along with when they were fetched.
+type jwksCache struct {
+	mu        sync.Mutex
+	fetchedAt time.Time
+	keys      map[string]*rsa.PublicKey
+	uri       string
+}
+
+// key returns the RSA public key with the given key id, refreshing
+// the cached key set from the provider's jwks_uri if it is stale or
+// does not contain kid.
+func (idp *identityProvider) key(kid string) (*rsa.PublicKey, error) {
+	d, err := idp.discoveryDoc()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	idp.mu.Lock()
+	if idp.keys == nil {
+		idp.keys = &jwksCache{uri: d.JWKSURI}
+	}
+	c := idp.keys
+	idp.mu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Follow the jwks_uri of the current discovery document, which
+	// may have changed since the cache was created.
+	c.uri = d.JWKSURI
+	if k, ok := c.keys[kid]; ok && time.Since(c.fetchedAt) < jwksRefreshInterval {
+		return k, nil
+	}
+	keys, err := fetchJWKS(c.uri)
+	if err != nil {
+		if k, ok := c.keys[kid]; ok {
+			// Fall back to the stale key set rather than
+			// breaking every login because the provider is
+			// temporarily unreachable.
+			return k, nil
+		}
+		return nil, errgo.Mask(err)
+	}
+	c.keys = keys
+	c.fetchedAt = time.Now()
+	k, ok := c.keys[kid]
+	if !ok {
+		return nil, errgo.Newf("no key found for kid %q", kid)
+	}
+	return k, nil
+}
+
+func fetchJWKS(uri string) (map[string]*rsa.PublicKey, error) {
+	resp, err := httpClient.Get(uri)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot fetch jwks")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errgo.Newf("cannot fetch jwks: unexpected status %q", resp.Status)
+	}
+	var set jwkSet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, errgo.Notef(err, "cannot decode jwks")
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || k.N == "" || k.E == "" {
+			continue
+		}
+		pub, err := rsaPublicKey(k.N, k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	if len(keys) == 0 {
+		return nil, errgo.Newf("jwks contained no usable RSA keys")
+	}
+	return keys, nil
+}
+
+// rsaPublicKey builds an *rsa.PublicKey from the base64url-encoded
+// modulus and exponent found in a JWK.
+func rsaPublicKey(n, e string) (*rsa.PublicKey, error) {
+	nb, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid modulus")
+	}
+	eb, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid exponent")
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nb),
+		E: int(new(big.Int).SetBytes(eb).Int64()),
+	}, nil
+}
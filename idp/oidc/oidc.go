@@ -0,0 +1,290 @@
+// Copyright 2017 Canonical Ltd.
+
+// Package oidc is an identity provider that authenticates against any
+// OpenID Connect compliant identity provider discovered through its
+// issuer's well-known configuration document (Keycloak, Dex, Google,
+// Azure AD, etc), as opposed to being tied to Ubuntu SSO specifically.
+package oidc
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/CanonicalLtd/blues-identity/config"
+	"github.com/CanonicalLtd/blues-identity/idp"
+	"github.com/CanonicalLtd/blues-identity/idp/idputil"
+)
+
+func init() {
+	config.RegisterIDP("oidc", func(unmarshal func(interface{}) error) (idp.IdentityProvider, error) {
+		var p Params
+		if err := unmarshal(&p); err != nil {
+			return nil, errgo.Notef(err, "cannot unmarshal oidc parameters")
+		}
+		return NewIdentityProvider(p)
+	})
+}
+
+// Params holds the configuration for an oidc identity provider.
+type Params struct {
+	// Name is the name that will be used for the identity provider.
+	Name string `yaml:"name"`
+
+	// IssuerURL is the base URL of the OpenID Connect issuer. The
+	// provider's discovery document is expected to be found at
+	// IssuerURL + "/.well-known/openid-configuration".
+	IssuerURL string `yaml:"issuer_url"`
+
+	// ClientID is the OAuth2 client id registered with the issuer.
+	ClientID string `yaml:"client_id"`
+
+	// ClientSecret is the OAuth2 client secret registered with the
+	// issuer.
+	ClientSecret string `yaml:"client_secret"`
+
+	// Scopes holds the OAuth2 scopes to request in addition to the
+	// mandatory "openid" scope.
+	Scopes []string `yaml:"scopes"`
+
+	// ClaimMapper derives the Candid groups a user should belong to
+	// from the ID token's claims.
+	ClaimMapper idp.ClaimMapper `yaml:"claim_mapping"`
+}
+
+// IdentityProvider is an idp.IdentityProvider that authenticates
+// against a generic OpenID Connect issuer.
+type IdentityProvider idp.IdentityProvider
+
+// NewIdentityProvider creates an idp.IdentityProvider that
+// authenticates using the OpenID Connect issuer described by p. The
+// issuer's discovery document is not fetched until the provider is
+// first used, so that a misbehaving or unreachable issuer does not
+// prevent Candid from starting up.
+func NewIdentityProvider(p Params) (idp.IdentityProvider, error) {
+	if p.IssuerURL == "" {
+		return nil, errgo.Newf("oidc identity provider requires an issuer_url")
+	}
+	if p.ClientID == "" {
+		return nil, errgo.Newf("oidc identity provider requires a client_id")
+	}
+	if err := p.ClaimMapper.Compile(); err != nil {
+		return nil, errgo.Notef(err, "invalid claim_mapping")
+	}
+	name := p.Name
+	if name == "" {
+		name = "oidc"
+	}
+	return &identityProvider{
+		name:   name,
+		params: p,
+		states: make(map[string]stateEntry),
+	}, nil
+}
+
+// identityProvider allows login using a generic OpenID Connect
+// authorization code flow.
+type identityProvider struct {
+	name   string
+	params Params
+
+	mu                 sync.Mutex
+	discovery          *discoveryDoc
+	discoveryFetchedAt time.Time
+	keys               *jwksCache
+	states             map[string]stateEntry
+}
+
+// stateEntry records the nonce, callback URL and expiry associated
+// with an outstanding authorization request, so that Handle can
+// validate the returned ID token's nonce, send the token endpoint the
+// exact same redirect_uri that was used in the authorization request
+// (as OAuth2 requires), and reject login attempts that have taken too
+// long.
+type stateEntry struct {
+	nonce       string
+	redirectURI string
+	expires     time.Time
+}
+
+const stateTTL = 10 * time.Minute
+
+// Name gives the name of the identity provider.
+func (idp *identityProvider) Name() string {
+	return idp.name
+}
+
+// Description gives a description of the identity provider.
+func (idp *identityProvider) Description() string {
+	return "OpenID Connect (" + idp.params.IssuerURL + ")"
+}
+
+// Interactive specifies that this identity provider is interactive,
+// as it requires the user's browser to be redirected to the issuer.
+func (idp *identityProvider) Interactive() bool {
+	return true
+}
+
+// URL gets the login URL to use this identity provider.
+func (idp *identityProvider) URL(c idp.URLContext, waitID string) (string, error) {
+	d, err := idp.discoveryDoc()
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	callback := c.URL("/callback")
+	if waitID != "" {
+		callback += "?waitid=" + waitID
+	}
+	state, nonce, err := idp.newState(callback)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	v := url.Values{
+		"response_type": {"code"},
+		"client_id":     {idp.params.ClientID},
+		"redirect_uri":  {callback},
+		"scope":         {strings.Join(append([]string{"openid"}, idp.params.Scopes...), " ")},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	u, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", errgo.Notef(err, "invalid authorization_endpoint %q", d.AuthorizationEndpoint)
+	}
+	u.RawQuery = v.Encode()
+	return u.String(), nil
+}
+
+// Handle handles the OpenID Connect authorization code callback.
+func (idp *identityProvider) Handle(c idp.Context) {
+	req := c.Params().Request
+	req.ParseForm()
+	code := req.Form.Get("code")
+	state := req.Form.Get("state")
+	if errMsg := req.Form.Get("error"); errMsg != "" {
+		c.LoginFailure(errgo.Newf("oidc login failed: %s", errMsg))
+		return
+	}
+	nonce, redirectURI, ok := idp.takeState(state)
+	if !ok {
+		c.LoginFailure(errgo.Newf("invalid or expired oidc state"))
+		return
+	}
+	tok, err := idp.exchangeCode(code, redirectURI)
+	if err != nil {
+		c.LoginFailure(errgo.Notef(err, "cannot exchange authorization code"))
+		return
+	}
+	claims, err := idp.verifyIDToken(tok.IDToken, nonce)
+	if err != nil {
+		c.LoginFailure(errgo.Notef(err, "cannot verify id token"))
+		return
+	}
+	externalID := idp.params.IssuerURL + "#" + claims.Subject
+	// Every group a user ends up with must have passed through
+	// ClaimMapper: claims.Raw already carries the "groups" claim
+	// (among everything else), so a claim_mapping rule that reads
+	// it gets the same raw values this used to merge in unfiltered,
+	// bypassing any prefix/rename/filter the deployment configured.
+	groups, isAdmin := idp.params.ClaimMapper.Groups(claims.Raw)
+	u, err := c.FindUserByExternalId(externalID)
+	if err != nil {
+		u, err = idp.userFromClaims(c, externalID, claims, groups, isAdmin)
+		if err != nil {
+			c.LoginFailure(errgo.Notef(err, "cannot create user for %q", externalID))
+			return
+		}
+	} else if err := idputil.UpdateUserGroups(c, externalID, groups, isAdmin); err != nil {
+		c.LoginFailure(errgo.Notef(err, "cannot update groups for %q", externalID))
+		return
+	}
+	idputil.LoginUser(c, u)
+}
+
+// newState generates a random, unguessable state value, records its
+// associated nonce and redirect_uri and returns the state and nonce
+// so that URL can include them in the authorization request.
+func (idp *identityProvider) newState(redirectURI string) (state, nonce string, err error) {
+	state, err = randomToken()
+	if err != nil {
+		return "", "", errgo.Mask(err)
+	}
+	nonce, err = randomToken()
+	if err != nil {
+		return "", "", errgo.Mask(err)
+	}
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.expireStatesLocked()
+	idp.states[state] = stateEntry{
+		nonce:       nonce,
+		redirectURI: redirectURI,
+		expires:     time.Now().Add(stateTTL),
+	}
+	return state, nonce, nil
+}
+
+// takeState looks up and removes the nonce and redirect_uri
+// associated with state, so that the state cannot be replayed.
+func (idp *identityProvider) takeState(state string) (nonce, redirectURI string, ok bool) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	e, ok := idp.states[state]
+	delete(idp.states, state)
+	if !ok || time.Now().After(e.expires) {
+		return "", "", false
+	}
+	return e.nonce, e.redirectURI, true
+}
+
+// expireStatesLocked removes expired entries from idp.states. It must
+// be called with idp.mu held.
+func (idp *identityProvider) expireStatesLocked() {
+	now := time.Now()
+	for k, e := range idp.states {
+		if now.After(e.expires) {
+			delete(idp.states, k)
+		}
+	}
+}
+
+func randomToken() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", errgo.Notef(err, "cannot generate random token")
+	}
+	return base64.RawURLEncoding.EncodeToString(b[:]), nil
+}
+
+// userFromClaims creates a new Candid user for an id token that has
+// not been seen before, deriving the username, email and groups from
+// the token's claims, then returns the newly created user in the same
+// way FindUserByExternalId would have.
+func (idp *identityProvider) userFromClaims(c idp.Context, externalID string, claims *idTokenClaims, groups []string, isAdmin bool) (interface{}, error) {
+	username := claims.PreferredUsername
+	if username == "" {
+		username = claims.Subject
+	}
+	err := idputil.UpdateUser(c, &idputil.UserUpdate{
+		ExternalID: externalID,
+		Username:   idp.name + "-" + username,
+		Email:      claims.Email,
+		FullName:   claims.Name,
+		Groups:     groups,
+		IsAdmin:    isAdmin,
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return c.FindUserByExternalId(externalID)
+}
+
+// httpClient is the client used for discovery, JWKS and token
+// endpoint requests. It is a variable so that tests can replace it.
+var httpClient = http.DefaultClient
@@ -0,0 +1,56 @@
+// Copyright 2017 Canonical Ltd.
+
+package oidc
+
+import (
+	"net/url"
+	"testing"
+)
+
+// testURLContext is a minimal idp.URLContext that mimics how the
+// server builds callback URLs.
+type testURLContext struct {
+	base string
+}
+
+func (c testURLContext) URL(path string) string {
+	return c.base + path
+}
+
+func TestURLAndExchangeCodeUseTheSameRedirectURI(t *testing.T) {
+	idp := &identityProvider{
+		params: Params{
+			IssuerURL: "https://issuer.example.com",
+			ClientID:  "candid",
+		},
+		discovery: &discoveryDoc{
+			Issuer:                "https://issuer.example.com",
+			AuthorizationEndpoint: "https://issuer.example.com/auth",
+			TokenEndpoint:         "https://issuer.example.com/token",
+			JWKSURI:               "https://issuer.example.com/jwks",
+		},
+		states: make(map[string]stateEntry),
+	}
+
+	authURL, err := idp.URL(testURLContext{base: "https://candid.example.com"}, "abc123")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRedirect := u.Query().Get("redirect_uri")
+	state := u.Query().Get("state")
+	if wantRedirect == "" || state == "" {
+		t.Fatalf("authorization URL missing redirect_uri or state: %s", authURL)
+	}
+
+	_, gotRedirect, ok := idp.takeState(state)
+	if !ok {
+		t.Fatal("state was not recorded")
+	}
+	if gotRedirect != wantRedirect {
+		t.Fatalf("redirect_uri mismatch: URL() used %q, exchange would use %q", wantRedirect, gotRedirect)
+	}
+}
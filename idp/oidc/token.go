@@ -0,0 +1,53 @@
+// Copyright 2017 Canonical Ltd.
+
+package oidc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// tokenResponse holds the fields of the token endpoint response that
+// Candid needs.
+type tokenResponse struct {
+	IDToken string `json:"id_token"`
+}
+
+// exchangeCode swaps an authorization code for an ID token at the
+// provider's token endpoint. redirectURI must be byte-for-byte the
+// same value sent as redirect_uri in the authorization request, as
+// required by RFC 6749 section 4.1.3 -- callers must pass the
+// redirectURI recorded alongside the state that accompanied code.
+func (idp *identityProvider) exchangeCode(code, redirectURI string) (*tokenResponse, error) {
+	d, err := idp.discoveryDoc()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	v := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {idp.params.ClientID},
+		"client_secret": {idp.params.ClientSecret},
+	}
+	resp, err := httpClient.PostForm(d.TokenEndpoint, v)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot contact token endpoint")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errgo.Newf("token endpoint returned unexpected status %q", resp.Status)
+	}
+	var tok tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return nil, errgo.Notef(err, "cannot decode token response")
+	}
+	if tok.IDToken == "" || strings.Count(tok.IDToken, ".") != 2 {
+		return nil, errgo.Newf("token response did not contain a valid id_token")
+	}
+	return &tok, nil
+}
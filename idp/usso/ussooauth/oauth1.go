@@ -0,0 +1,289 @@
+// Copyright 2017 Canonical Ltd.
+
+package ussooauth
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// TokenStore looks up the consumer and token secrets registered for
+// an OAuth1 consumer key, so that verifyOAuthSignature can validate a
+// request's signature locally instead of asking login.ubuntu.com to
+// do it on every request.
+type TokenStore interface {
+	// Secrets returns the consumer secret and token secret
+	// registered for consumerKey. It returns an error if
+	// consumerKey is not recognised.
+	Secrets(consumerKey string) (consumerSecret, tokenSecret string, err error)
+}
+
+// ConsumerSecrets holds the consumer and token secrets registered for
+// one OAuth1 consumer key, for use with Params.Tokens.
+type ConsumerSecrets struct {
+	ConsumerSecret string `yaml:"consumer_secret"`
+	TokenSecret    string `yaml:"token_secret"`
+}
+
+// staticTokenStore is a TokenStore backed by a fixed map of consumer
+// key to secrets, used as Params.TokenStore's fallback when a
+// deployment configures Params.Tokens instead of providing its own
+// TokenStore.
+type staticTokenStore map[string]ConsumerSecrets
+
+// Secrets implements TokenStore.
+func (s staticTokenStore) Secrets(consumerKey string) (string, string, error) {
+	secrets, ok := s[consumerKey]
+	if !ok {
+		return "", "", errgo.Newf("unknown oauth consumer key %q", consumerKey)
+	}
+	return secrets.ConsumerSecret, secrets.TokenSecret, nil
+}
+
+// oauthParams holds the "oauth_*" parameters parsed from a request's
+// Authorization header.
+type oauthParams struct {
+	consumerKey     string
+	token           string
+	signatureMethod string
+	signature       string
+	timestamp       string
+	nonce           string
+}
+
+var oauthParamRegexp = regexp.MustCompile(`(oauth_[a-z_]+)="([^"]*)"`)
+
+// verifyOAuthSignature verifies that req is correctly signed by the
+// owner of the consumer key named in its Authorization header,
+// without making a network request: it looks the consumer's secrets
+// up in idp.params.TokenStore and checks the signature itself per
+// RFC 5849.
+func (idp *identityProvider) verifyOAuthSignature(requestURL string, req *http.Request) (string, error) {
+	if idp.params.TokenStore == nil {
+		return "", errgo.Newf("usso_oauth identity provider is not configured with a TokenStore")
+	}
+	req.ParseForm()
+	p, err := parseOAuthParams(req.Header.Get("Authorization"))
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	if err := idp.checkTimestamp(p.timestamp); err != nil {
+		return "", errgo.Mask(err)
+	}
+	if !idp.nonces.checkAndAdd(p.consumerKey, p.nonce, p.timestamp) {
+		return "", errgo.Newf("oauth nonce has already been used")
+	}
+	consumerSecret, tokenSecret, err := idp.params.TokenStore.Secrets(p.consumerKey)
+	if err != nil {
+		return "", errgo.Notef(err, "cannot find secrets for consumer key %q", p.consumerKey)
+	}
+	base, err := signatureBaseString(requestURL, req)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	ok, err := verifySignature(p.signatureMethod, base, consumerSecret, tokenSecret, p.signature)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	if !ok {
+		return "", errgo.Newf("invalid oauth signature")
+	}
+	return ussoURL + "/+id/" + p.consumerKey, nil
+}
+
+// parseOAuthParams extracts the "oauth_*" parameters from the value
+// of a request's Authorization header.
+func parseOAuthParams(header string) (oauthParams, error) {
+	var p oauthParams
+	for _, m := range oauthParamRegexp.FindAllStringSubmatch(header, -1) {
+		name, value := m[1], m[2]
+		unescaped, err := url.QueryUnescape(value)
+		if err != nil {
+			return oauthParams{}, errgo.Notef(err, "cannot unescape %s", name)
+		}
+		switch name {
+		case "oauth_consumer_key":
+			p.consumerKey = unescaped
+		case "oauth_token":
+			p.token = unescaped
+		case "oauth_signature_method":
+			p.signatureMethod = unescaped
+		case "oauth_signature":
+			p.signature = unescaped
+		case "oauth_timestamp":
+			p.timestamp = unescaped
+		case "oauth_nonce":
+			p.nonce = unescaped
+		}
+	}
+	if p.consumerKey == "" {
+		return oauthParams{}, errgo.Newf("no oauth_consumer_key in authorization")
+	}
+	if p.signature == "" {
+		return oauthParams{}, errgo.Newf("no oauth_signature in authorization")
+	}
+	if p.timestamp == "" || p.nonce == "" {
+		return oauthParams{}, errgo.Newf("no oauth_timestamp or oauth_nonce in authorization")
+	}
+	return p, nil
+}
+
+// checkTimestamp rejects requests whose oauth_timestamp falls outside
+// the configured skew window around the current time.
+func (idp *identityProvider) checkTimestamp(timestamp string) error {
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errgo.Newf("invalid oauth_timestamp %q", timestamp)
+	}
+	t := time.Unix(secs, 0)
+	age := time.Since(t)
+	if age < 0 {
+		age = -age
+	}
+	if age > idp.skew {
+		return errgo.Newf("oauth_timestamp %q is outside the allowed window", timestamp)
+	}
+	return nil
+}
+
+// signatureBaseString builds the RFC 5849 section 3.4.1 signature
+// base string for req: the uppercased HTTP method, the normalized
+// request URL and the sorted, percent-encoded set of form and query
+// parameters (excluding oauth_signature), joined with "&".
+func signatureBaseString(requestURL string, req *http.Request) (string, error) {
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return "", errgo.Notef(err, "cannot parse request URL")
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+
+	params := make(url.Values)
+	for k, vs := range req.Form {
+		params[k] = append(params[k], vs...)
+	}
+	for _, m := range oauthParamRegexp.FindAllStringSubmatch(req.Header.Get("Authorization"), -1) {
+		name := m[1]
+		if name == "oauth_signature" {
+			continue
+		}
+		value, err := url.QueryUnescape(m[2])
+		if err != nil {
+			return "", errgo.Notef(err, "cannot unescape %s", name)
+		}
+		params[name] = append(params[name], value)
+	}
+
+	var keys []string
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		vs := params[k]
+		sort.Strings(vs)
+		for _, v := range vs {
+			pairs = append(pairs, encode(k)+"="+encode(v))
+		}
+	}
+	normalizedParams := strings.Join(pairs, "&")
+
+	return strings.Join([]string{
+		strings.ToUpper(req.Method),
+		encode(u.String()),
+		encode(normalizedParams),
+	}, "&"), nil
+}
+
+// verifySignature checks signature against base using the given
+// OAuth1 signature method and secrets, in constant time.
+func verifySignature(method, base, consumerSecret, tokenSecret, signature string) (bool, error) {
+	key := encode(consumerSecret) + "&" + encode(tokenSecret)
+	var want string
+	switch method {
+	case "HMAC-SHA1":
+		mac := hmac.New(sha1.New, []byte(key))
+		mac.Write([]byte(base))
+		want = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	case "PLAINTEXT":
+		want = key
+	default:
+		return false, errgo.Newf("unsupported oauth_signature_method %q", method)
+	}
+	return subtle.ConstantTimeCompare([]byte(want), []byte(signature)) == 1, nil
+}
+
+// encode percent-encodes s as required by RFC 5849 section 3.6,
+// which, unlike url.QueryEscape, encodes a space as "%20" rather
+// than "+".
+func encode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// defaultNonceCacheSize bounds the number of recently seen nonces
+// kept in memory, across all consumer keys together, so that the
+// cache cannot grow without bound under sustained traffic.
+const defaultNonceCacheSize = 10000
+
+// nonceCache is a bounded, in-memory LRU of nonces that have recently
+// been used to sign a request, keyed by consumer key, nonce and
+// timestamp, so that a captured request cannot be replayed.
+type nonceCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+func newNonceCache(size int) *nonceCache {
+	return &nonceCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// checkAndAdd reports whether the given consumer key, nonce and
+// timestamp have not been seen before, recording them if so.
+func (c *nonceCache) checkAndAdd(consumerKey, nonce, timestamp string) bool {
+	key := consumerKey + "\x00" + nonce + "\x00" + timestamp
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return false
+	}
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		if oldest != nil {
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(string))
+		}
+	}
+	c.entries[key] = c.order.PushFront(key)
+	return true
+}
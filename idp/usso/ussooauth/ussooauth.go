@@ -5,13 +5,8 @@
 package ussooauth
 
 import (
-	"bytes"
-	"encoding/json"
-	"io/ioutil"
-	"mime"
 	"net/http"
-	"net/url"
-	"regexp"
+	"time"
 
 	"gopkg.in/errgo.v1"
 
@@ -21,22 +16,92 @@ import (
 )
 
 func init() {
-	config.RegisterIDP("usso_oauth", func(func(interface{}) error) (idp.IdentityProvider, error) {
-		return IdentityProvider, nil
+	config.RegisterIDP("usso_oauth", func(unmarshal func(interface{}) error) (idp.IdentityProvider, error) {
+		var p Params
+		if err := unmarshal(&p); err != nil {
+			return nil, errgo.Notef(err, "cannot unmarshal usso_oauth parameters")
+		}
+		if err := p.ClaimMapper.Compile(); err != nil {
+			return nil, errgo.Notef(err, "invalid claim_mapping")
+		}
+		return NewIdentityProvider(p), nil
 	})
 }
 
+// Params holds the configuration for the usso_oauth identity
+// provider.
+type Params struct {
+	// TokenStore looks up the consumer and token secrets needed to
+	// verify a request's OAuth signature locally. It must be set by
+	// the code that configures Candid; it has no YAML
+	// representation. If it is nil, Secrets falls back to the
+	// statically configured Tokens.
+	TokenStore TokenStore `yaml:"-"`
+
+	// Tokens statically configures the consumer and token secrets
+	// for known OAuth1 consumer keys, for deployments that have no
+	// separate secrets store to provide a TokenStore. It is ignored
+	// when TokenStore is set.
+	Tokens map[string]ConsumerSecrets `yaml:"tokens"`
+
+	// TimestampSkew bounds how far from the current time an
+	// oauth_timestamp may be before the request is rejected. It
+	// defaults to 5 minutes.
+	TimestampSkew time.Duration `yaml:"timestamp_skew"`
+
+	// ClaimMapper derives the Candid groups a user should belong to
+	// from their Ubuntu SSO account details.
+	ClaimMapper idp.ClaimMapper `yaml:"claim_mapping"`
+
+	// Teams lists the Launchpad/Ubuntu SSO teams whose membership
+	// should be made available to ClaimMapper under the "groups"
+	// claim. Ubuntu SSO reports membership of these teams as extra
+	// "is_team_member:<team>" parameters on the signed callback
+	// request, so only teams named here can ever be seen by
+	// ClaimMapper -- there is no separate Ubuntu SSO API call to
+	// fetch team membership out of band.
+	Teams []string `yaml:"teams"`
+}
+
+// defaultTimestampSkew is used when Params.TimestampSkew is zero.
+const defaultTimestampSkew = 5 * time.Minute
+
 // IdentityProvider is an idp.IdentityProvider that provides
-// authentication via Ubuntu SSO using OAuth.
-var IdentityProvider idp.IdentityProvider = (*identityProvider)(nil)
+// authentication via Ubuntu SSO using OAuth, with no TokenStore or
+// group mapping configured. Deployments that need to verify OAuth
+// signatures locally or map Ubuntu SSO teams onto Candid groups
+// should call NewIdentityProvider instead.
+var IdentityProvider idp.IdentityProvider = NewIdentityProvider(Params{})
 
 const (
 	ussoURL = "https://login.ubuntu.com"
 )
 
+// NewIdentityProvider returns an idp.IdentityProvider that
+// authenticates using request signing with Ubuntu SSO OAuth tokens,
+// configured as described by p.
+func NewIdentityProvider(p Params) idp.IdentityProvider {
+	skew := p.TimestampSkew
+	if skew == 0 {
+		skew = defaultTimestampSkew
+	}
+	if p.TokenStore == nil && len(p.Tokens) > 0 {
+		p.TokenStore = staticTokenStore(p.Tokens)
+	}
+	return &identityProvider{
+		params: p,
+		skew:   skew,
+		nonces: newNonceCache(defaultNonceCacheSize),
+	}
+}
+
 // identityProvider allows login using request signing with
 // Ubuntu SSO OAuth tokens.
-type identityProvider struct{}
+type identityProvider struct {
+	params Params
+	skew   time.Duration
+	nonces *nonceCache
+}
 
 // Name gives the name of the identity provider (usso_oauth).
 func (*identityProvider) Name() string {
@@ -63,8 +128,8 @@ func (*identityProvider) URL(c idp.URLContext, waitID string) (string, error) {
 }
 
 // Handle handles the Ubuntu SSO OAuth login process.
-func (*identityProvider) Handle(c idp.Context) {
-	id, err := verifyOAuthSignature(c.RequestURL(), c.Params().Request)
+func (idp *identityProvider) Handle(c idp.Context) {
+	id, err := idp.verifyOAuthSignature(c.RequestURL(), c.Params().Request)
 	if err != nil {
 		c.LoginFailure(err)
 		return
@@ -74,64 +139,29 @@ func (*identityProvider) Handle(c idp.Context) {
 		c.LoginFailure(errgo.Notef(err, "cannot get user details for %q", id))
 		return
 	}
+	groups, isAdmin := idp.params.ClaimMapper.Groups(map[string]interface{}{
+		"external_id": id,
+		"groups":      idp.teamMembership(c.Params().Request),
+	})
+	if len(groups) > 0 || isAdmin {
+		if err := idputil.UpdateUserGroups(c, id, groups, isAdmin); err != nil {
+			c.LoginFailure(errgo.Notef(err, "cannot update groups for %q", id))
+			return
+		}
+	}
 	idputil.LoginUser(c, u)
 }
 
-var consumerKeyRegexp = regexp.MustCompile(`oauth_consumer_key="([^"]*)"`)
-
-// verifyOAuthSignature verifies with Ubuntu SSO that the request is correctly
-// signed.
-func verifyOAuthSignature(requestURL string, req *http.Request) (string, error) {
-	req.ParseForm()
-	u, err := url.Parse(requestURL)
-	if err != nil {
-		return "", errgo.Notef(err, "cannot parse request URL")
-	}
-	u.RawQuery = ""
-	request := struct {
-		URL           string `json:"http_url"`
-		Method        string `json:"http_method"`
-		Authorization string `json:"authorization"`
-		QueryString   string `json:"query_string"`
-	}{
-		URL:           u.String(),
-		Method:        req.Method,
-		Authorization: req.Header.Get("Authorization"),
-		QueryString:   req.Form.Encode(),
-	}
-	buf, err := json.Marshal(request)
-	if err != nil {
-		return "", errgo.Notef(err, "cannot marshal request")
-	}
-	resp, err := http.Post(ussoURL+"/api/v2/requests/validate", "application/json", bytes.NewReader(buf))
-	if err != nil {
-		return "", errgo.Mask(err)
-	}
-	defer resp.Body.Close()
-	t, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
-	if err != nil {
-		return "", errgo.Newf("bad content type %q", resp.Header.Get("Content-Type"))
+// teamMembership returns the configured Teams that Ubuntu SSO
+// reported req's caller as a member of, read from the
+// "is_team_member:<team>" parameters Ubuntu SSO adds to the signed
+// callback request for every requested team.
+func (idp *identityProvider) teamMembership(req *http.Request) []string {
+	var teams []string
+	for _, team := range idp.params.Teams {
+		if req.Form.Get("is_team_member:"+team) == "True" {
+			teams = append(teams, team)
+		}
 	}
-	if t != "application/json" {
-		return "", errgo.Newf("unexpected response type %q", t)
-	}
-	var validated struct {
-		IsValid bool   `json:"is_valid"`
-		Error   string `json:"error"`
-	}
-	data, err := ioutil.ReadAll(resp.Body)
-	if err := json.Unmarshal(data, &validated); err != nil {
-		return "", errgo.Mask(err)
-	}
-	if validated.Error != "" {
-		return "", errgo.Newf("cannot validate OAuth credentials: %s", validated.Error)
-	}
-	if !validated.IsValid {
-		return "", errgo.Newf("invalid OAuth credentials")
-	}
-	consumerKey := consumerKeyRegexp.FindStringSubmatch(req.Header.Get("Authorization"))
-	if len(consumerKey) != 2 {
-		return "", errgo.Newf("no customer key in authorization")
-	}
-	return ussoURL + "/+id/" + consumerKey[1], nil
-}
\ No newline at end of file
+	return teams
+}
@@ -0,0 +1,85 @@
+// Copyright 2017 Canonical Ltd.
+
+package ussooauth
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/blues-identity/idp"
+)
+
+func TestNewIdentityProviderFallsBackToStaticTokenStore(t *testing.T) {
+	p := NewIdentityProvider(Params{
+		Tokens: map[string]ConsumerSecrets{
+			"consumer1": {ConsumerSecret: "consumersecret", TokenSecret: "tokensecret"},
+		},
+	})
+	idp, ok := p.(*identityProvider)
+	if !ok {
+		t.Fatalf("unexpected provider type %T", p)
+	}
+	requestURL := "https://candid.example.com/oauth"
+	req := signedRequest(t, "PLAINTEXT", requestURL, "consumer1", "consumersecret", "tokensecret", "nonce1", time.Now())
+	if _, err := idp.verifyOAuthSignature(requestURL, req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestNewIdentityProviderPrefersExplicitTokenStore(t *testing.T) {
+	explicit := fakeTokenStore{"consumer1": [2]string{"explicitsecret", "explicittoken"}}
+	p := NewIdentityProvider(Params{
+		TokenStore: explicit,
+		Tokens: map[string]ConsumerSecrets{
+			"consumer1": {ConsumerSecret: "staticsecret", TokenSecret: "statictoken"},
+		},
+	})
+	idp := p.(*identityProvider)
+	if _, ok := idp.params.TokenStore.(fakeTokenStore); !ok {
+		t.Fatalf("expected the explicit TokenStore to be kept, got %T", idp.params.TokenStore)
+	}
+}
+
+func TestTeamMembershipReadsConfiguredTeams(t *testing.T) {
+	idp := &identityProvider{params: Params{Teams: []string{"cloud-team", "other-team"}}}
+	req := &http.Request{}
+	req.Form = url.Values{
+		"is_team_member:cloud-team": {"True"},
+		"is_team_member:other-team": {"False"},
+	}
+	got := idp.teamMembership(req)
+	if want := []string{"cloud-team"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestTeamMembershipFeedsClaimMapper(t *testing.T) {
+	cm := idp.ClaimMapper{
+		Rules: []idp.ClaimRule{{
+			Claim: "groups",
+		}},
+		AdminGroups: []string{"admins"},
+	}
+	if err := cm.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	p := &identityProvider{params: Params{
+		Teams:       []string{"admins"},
+		ClaimMapper: cm,
+	}}
+	req := &http.Request{}
+	req.Form = url.Values{"is_team_member:admins": {"True"}}
+	groups, isAdmin := p.params.ClaimMapper.Groups(map[string]interface{}{
+		"external_id": "https://login.ubuntu.com/+id/bob",
+		"groups":      p.teamMembership(req),
+	})
+	if !isAdmin {
+		t.Fatalf("expected isAdmin to be true")
+	}
+	if want := []string{"admins"}; !reflect.DeepEqual(groups, want) {
+		t.Fatalf("got groups %v, want %v", groups, want)
+	}
+}
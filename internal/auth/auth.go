@@ -0,0 +1,50 @@
+// Copyright 2017 Canonical Ltd.
+
+// Package auth defines the bakery operations and actions used to
+// authorize access to the identity manager's API.
+package auth
+
+import (
+	"gopkg.in/juju/idmclient.v1/params"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+)
+
+// The following actions are used as the Action of a bakery.Op
+// returned by internal/v1's opForRequest. GlobalOp and UserOp attach
+// an action to the entity the action is performed against.
+const (
+	ActionRead         = "read"
+	ActionWriteAdmin   = "write-admin"
+	ActionReadAdmin    = "read-admin"
+	ActionCreateAgent  = "create-agent"
+	ActionReadGroups   = "read-groups"
+	ActionWriteGroups  = "write-groups"
+	ActionReadSSHKeys  = "read-sshkeys"
+	ActionWriteSSHKeys = "write-sshkeys"
+	ActionVerify       = "verify"
+	ActionDischargeFor = "discharge-for"
+	ActionSignSSH      = "sign-ssh"
+)
+
+// globalEntity is the entity used for operations that are not
+// associated with a particular user.
+const globalEntity = "global"
+
+// GlobalOp returns the bakery operation representing action being
+// performed against the identity manager as a whole, rather than
+// against a particular user.
+func GlobalOp(action string) bakery.Op {
+	return bakery.Op{
+		Entity: globalEntity,
+		Action: action,
+	}
+}
+
+// UserOp returns the bakery operation representing action being
+// performed against the given user.
+func UserOp(username params.Username, action string) bakery.Op {
+	return bakery.Op{
+		Entity: "u-" + string(username),
+		Action: action,
+	}
+}
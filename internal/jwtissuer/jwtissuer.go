@@ -0,0 +1,214 @@
+// Copyright 2017 Canonical Ltd.
+
+// Package jwtissuer signs JWT discharge tokens on behalf of Candid,
+// as an alternative to macaroon discharges for relying parties that
+// only understand OIDC/JWT (Kubernetes ingress controllers, Envoy,
+// and similar). It also publishes the signing keys as a JWK set so
+// that those parties can verify tokens without contacting Candid on
+// every request.
+package jwtissuer
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// Params holds the configuration for an Issuer.
+type Params struct {
+	// IssuerURL is the Candid URL used as the "iss" claim of issued
+	// tokens.
+	IssuerURL string
+
+	// RotateInterval is how often a new signing key is generated.
+	// The previous key is kept available for verification (and
+	// published in the JWKS) for one further interval, so that
+	// tokens signed just before a rotation remain verifiable.
+	// It defaults to 24 hours.
+	RotateInterval time.Duration
+}
+
+const defaultRotateInterval = 24 * time.Hour
+
+// Claims holds the claims of a JWT discharge token.
+type Claims struct {
+	Subject  string   `json:"sub"`
+	Issuer   string   `json:"iss"`
+	Audience string   `json:"aud"`
+	Expiry   int64    `json:"exp"`
+	IssuedAt int64    `json:"iat"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+// signingKey is one generation of RSA signing key.
+type signingKey struct {
+	kid       string
+	key       *rsa.PrivateKey
+	generated time.Time
+}
+
+// Issuer signs JWT discharge tokens and publishes the keys needed to
+// verify them. It rotates its signing key periodically; callers
+// should call MaybeRotate (for example from a periodic worker, or
+// lazily before every Sign) to apply rotation.
+type Issuer struct {
+	issuerURL      string
+	rotateInterval time.Duration
+
+	mu        sync.RWMutex
+	current   *signingKey
+	previous  *signingKey
+	retiredAt time.Time
+}
+
+// NewIssuer creates an Issuer configured as described by p, with a
+// freshly generated signing key.
+func NewIssuer(p Params) (*Issuer, error) {
+	if p.IssuerURL == "" {
+		return nil, errgo.Newf("jwtissuer requires an IssuerURL")
+	}
+	rotateInterval := p.RotateInterval
+	if rotateInterval == 0 {
+		rotateInterval = defaultRotateInterval
+	}
+	key, err := newSigningKey()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &Issuer{
+		issuerURL:      p.IssuerURL,
+		rotateInterval: rotateInterval,
+		current:        key,
+	}, nil
+}
+
+func newSigningKey() (*signingKey, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot generate signing key")
+	}
+	kid := strconv.FormatInt(time.Now().UnixNano(), 36)
+	return &signingKey{
+		kid:       kid,
+		key:       priv,
+		generated: time.Now(),
+	}, nil
+}
+
+// MaybeRotate generates a new signing key if the current one is
+// older than the configured rotation interval, retiring the previous
+// key so that it is no longer used for signing but remains available
+// for one further interval so in-flight tokens can still be
+// verified. It also drops a previously retired key once that further
+// interval has elapsed, so JWKS does not publish keys forever.
+func (iss *Issuer) MaybeRotate() error {
+	iss.mu.RLock()
+	stale := time.Since(iss.current.generated) > iss.rotateInterval
+	expired := iss.previous != nil && time.Since(iss.retiredAt) > iss.rotateInterval
+	iss.mu.RUnlock()
+	if !stale && !expired {
+		return nil
+	}
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	if expired && time.Since(iss.retiredAt) > iss.rotateInterval {
+		iss.previous = nil
+	}
+	if stale && time.Since(iss.current.generated) > iss.rotateInterval {
+		key, err := newSigningKey()
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		iss.previous = iss.current
+		iss.retiredAt = time.Now()
+		iss.current = key
+	}
+	return nil
+}
+
+// Sign issues a signed JWT discharge token for the given subject,
+// audience and groups, valid for ttl.
+func (iss *Issuer) Sign(subject, audience string, groups []string, ttl time.Duration) (string, error) {
+	iss.mu.RLock()
+	key := iss.current
+	iss.mu.RUnlock()
+
+	now := time.Now()
+	claims := Claims{
+		Subject:  subject,
+		Issuer:   iss.issuerURL,
+		Audience: audience,
+		IssuedAt: now.Unix(),
+		Expiry:   now.Add(ttl).Unix(),
+		Groups:   groups,
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+		Typ string `json:"typ"`
+	}{"RS256", key.kid, "JWT"}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." +
+		base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", errgo.Notef(err, "cannot sign token")
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// jwk is the subset of JSON Web Key fields needed to publish an RSA
+// public key.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS returns the issuer's current and (if not yet expired)
+// previous public keys as a JSON Web Key Set, suitable for serving
+// at /.well-known/jwks.json.
+func (iss *Issuer) JWKS() ([]byte, error) {
+	iss.mu.RLock()
+	keys := []*signingKey{iss.current}
+	if iss.previous != nil && time.Since(iss.retiredAt) < iss.rotateInterval {
+		keys = append(keys, iss.previous)
+	}
+	iss.mu.RUnlock()
+
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	for _, k := range keys {
+		set.Keys = append(set.Keys, jwk{
+			Kty: "RSA",
+			Kid: k.kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(k.key.PublicKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.PublicKey.E)).Bytes()),
+		})
+	}
+	return json.Marshal(set)
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 Canonical Ltd.
+
+package jwtissuer
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parseJWKS(t *testing.T, data []byte) map[string]*rsa.PublicKey {
+	t.Helper()
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		t.Fatal(err)
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			t.Fatal(err)
+		}
+		eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(nBytes),
+			E: int(new(big.Int).SetBytes(eBytes).Int64()),
+		}
+	}
+	return keys
+}
+
+func verifyJWT(t *testing.T, token string, keys map[string]*rsa.PublicKey) {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("malformed token %q", token)
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header struct {
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		t.Fatal(err)
+	}
+	key, ok := keys[header.Kid]
+	if !ok {
+		t.Fatalf("no published key for kid %q", header.Kid)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		t.Fatalf("signature verification failed: %v", err)
+	}
+}
+
+func TestSignProducesAVerifiableToken(t *testing.T) {
+	iss, err := NewIssuer(Params{IssuerURL: "https://candid.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := iss.Sign("alice", "myapp", []string{"admins"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := iss.JWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyJWT(t, token, parseJWKS(t, jwks))
+}
+
+func TestJWKSDropsPreviousKeyAfterItsRetirementWindow(t *testing.T) {
+	iss, err := NewIssuer(Params{
+		IssuerURL:      "https://candid.example.com",
+		RotateInterval: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKid := iss.current.kid
+
+	time.Sleep(2 * time.Millisecond)
+	if err := iss.MaybeRotate(); err != nil {
+		t.Fatal(err)
+	}
+	if iss.current.kid == oldKid {
+		t.Fatal("expected MaybeRotate to generate a new key")
+	}
+
+	// The retired key should still be published immediately after
+	// rotation, so tokens signed just before it are still verifiable.
+	jwks, err := iss.JWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := parseJWKS(t, jwks); keys[oldKid] == nil {
+		t.Fatalf("expected retired key %q to still be published", oldKid)
+	}
+
+	// Once the retirement window itself has elapsed, the old key
+	// must be dropped, both from JWKS and from the Issuer itself so
+	// it is not kept around forever.
+	time.Sleep(2 * time.Millisecond)
+	if err := iss.MaybeRotate(); err != nil {
+		t.Fatal(err)
+	}
+	jwks, err = iss.JWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := parseJWKS(t, jwks); keys[oldKid] != nil {
+		t.Fatalf("expected retired key %q to have been dropped", oldKid)
+	}
+}
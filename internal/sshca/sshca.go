@@ -0,0 +1,158 @@
+// Copyright 2017 Canonical Ltd.
+
+// Package sshca implements an SSH certificate authority that issues
+// short-lived OpenSSH user certificates for Candid users, so that
+// servers can trust Candid identities (via TrustedUserCAKeys) instead
+// of running a separate SSH login stack.
+package sshca
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/errgo.v1"
+)
+
+// Params holds the configuration for a CA.
+type Params struct {
+	// PrivateKeyPath is the path to a PEM-encoded SSH private key
+	// used to sign issued certificates.
+	PrivateKeyPath string `yaml:"private_key_path"`
+
+	// MaxValidity bounds how long a caller may request an issued
+	// certificate to remain valid for. It defaults to one hour.
+	MaxValidity time.Duration `yaml:"max_validity"`
+
+	// Groups maps a Candid group name to the certificate options
+	// granted to members of that group. When a user is a member of
+	// more than one configured group, the options from every
+	// matching group are merged into the issued certificate.
+	Groups map[string]GroupOptions `yaml:"groups"`
+}
+
+// GroupOptions holds the certificate options granted to members of a
+// Candid group.
+type GroupOptions struct {
+	// CriticalOptions is merged into the issued certificate's
+	// critical options, for example "force-command" or
+	// "source-address".
+	CriticalOptions map[string]string `yaml:"critical_options"`
+
+	// Extensions is merged into the issued certificate's
+	// extensions, for example "permit-pty".
+	Extensions map[string]string `yaml:"extensions"`
+}
+
+// defaultMaxValidity is used when Params.MaxValidity is zero.
+const defaultMaxValidity = time.Hour
+
+// CA signs OpenSSH user certificates on behalf of Candid users.
+type CA struct {
+	signer      ssh.Signer
+	groups      map[string]GroupOptions
+	maxValidity time.Duration
+}
+
+// New creates a CA configured as described by p.
+func New(p Params) (*CA, error) {
+	key, err := ioutil.ReadFile(p.PrivateKeyPath)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot read CA private key")
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot parse CA private key")
+	}
+	maxValidity := p.MaxValidity
+	if maxValidity == 0 {
+		maxValidity = defaultMaxValidity
+	}
+	return &CA{
+		signer:      signer,
+		groups:      p.Groups,
+		maxValidity: maxValidity,
+	}, nil
+}
+
+// PublicKey returns the CA's public key in "authorized_keys" format,
+// suitable for a server's TrustedUserCAKeys file.
+func (ca *CA) PublicKey() string {
+	return string(ssh.MarshalAuthorizedKey(ca.signer.PublicKey()))
+}
+
+// Sign issues a short-lived OpenSSH user certificate for the public
+// key in authorizedKey (in "authorized_keys" format), valid for the
+// given principals and for validity (capped at ca.maxValidity). The
+// certificate's critical options and extensions are the union of
+// those configured for every group in groups.
+func (ca *CA) Sign(authorizedKey string, principals, groups []string, validity time.Duration) (string, error) {
+	if validity <= 0 || validity > ca.maxValidity {
+		validity = ca.maxValidity
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return "", errgo.Notef(err, "invalid public key")
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             pub,
+		Serial:          serial,
+		CertType:        ssh.UserCert,
+		KeyId:           principalsKeyID(principals),
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(now.Add(validity).Unix()),
+		Permissions:     ca.permissions(groups),
+	}
+	if err := cert.SignCert(rand.Reader, ca.signer); err != nil {
+		return "", errgo.Notef(err, "cannot sign certificate")
+	}
+	return string(ssh.MarshalAuthorizedKey(cert)), nil
+}
+
+// permissions merges the critical options and extensions configured
+// for every group in groups.
+func (ca *CA) permissions(groups []string) ssh.Permissions {
+	perm := ssh.Permissions{
+		CriticalOptions: make(map[string]string),
+		Extensions:      make(map[string]string),
+	}
+	for _, g := range groups {
+		opts, ok := ca.groups[g]
+		if !ok {
+			continue
+		}
+		for k, v := range opts.CriticalOptions {
+			perm.CriticalOptions[k] = v
+		}
+		for k, v := range opts.Extensions {
+			perm.Extensions[k] = v
+		}
+	}
+	return perm
+}
+
+func principalsKeyID(principals []string) string {
+	if len(principals) == 0 {
+		return "candid"
+	}
+	return "candid-" + principals[0]
+}
+
+func randomSerial() (uint64, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, errgo.Notef(err, "cannot generate certificate serial")
+	}
+	var serial uint64
+	for _, c := range b {
+		serial = serial<<8 | uint64(c)
+	}
+	return serial, nil
+}
@@ -0,0 +1,36 @@
+// Copyright 2017 Canonical Ltd.
+
+package v1
+
+import (
+	"context"
+	"net/http"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+	"gopkg.in/juju/idmclient.v1/params"
+)
+
+// errToResp maps an error returned by a Handler method to the HTTP
+// status and body used to report it, so that every v1 endpoint
+// reports failures as the same params.Error shape.
+func errToResp(ctx context.Context, err error) (int, interface{}) {
+	status := http.StatusInternalServerError
+	if errgo.Cause(err) == params.ErrForbidden {
+		status = http.StatusForbidden
+	}
+	return status, &params.Error{Message: err.Error()}
+}
+
+var errorMapper httprequest.ErrorMapper = errToResp
+
+// NewAPIHandler returns the httprequest handlers for the v1 API
+// endpoints served directly by h (the batch, SSH certificate
+// authority and JWT discharge/JWKS endpoints), for installation into
+// the identity manager's HTTP router alongside the handlers for the
+// other params.* request types dispatched through opForRequest.
+func NewAPIHandler(h *Handler) []httprequest.Handler {
+	return errorMapper.Handlers(func(p httprequest.Params) (*Handler, context.Context, error) {
+		return h, p.Context, nil
+	})
+}
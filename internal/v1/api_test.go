@@ -0,0 +1,97 @@
+// Copyright 2017 Canonical Ltd.
+
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gopkg.in/httprequest.v1"
+	"gopkg.in/juju/idmclient.v1/params"
+)
+
+// findHandler returns the httprequest.Handler NewAPIHandler registered
+// for method and path, so that a test can drive it the way the real
+// HTTP router would, rather than calling a Handler method directly.
+func findHandler(t *testing.T, handlers []httprequest.Handler, method, path string) httprequest.Handler {
+	t.Helper()
+	for _, h := range handlers {
+		if h.Method == method && h.Path == path {
+			return h
+		}
+	}
+	t.Fatalf("no handler registered for %s %s", method, path)
+	return httprequest.Handler{}
+}
+
+func TestBatchSetUsersIsRoutedOverHTTP(t *testing.T) {
+	h := &Handler{
+		Store: newFakeStore(),
+		Checker: &fakeChecker{allowedEntities: map[string]bool{
+			"u-alice": true,
+		}},
+	}
+	handler := findHandler(t, NewAPIHandler(h), "POST", "/batch/users")
+
+	body, err := json.Marshal([]params.User{{Username: "alice"}, {Username: "bob"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/batch/users", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.Handle(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+	results := readBatchResults(t, rec.Body)
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	if results[0].Error != nil {
+		t.Fatalf("expected alice to be authorized, got error %v", results[0].Error)
+	}
+	if results[1].Error == nil {
+		t.Fatalf("expected bob to be forbidden")
+	}
+}
+
+func TestSSHCAKeysIsRoutedOverHTTP(t *testing.T) {
+	h := &Handler{Store: newFakeStore(), SSHCA: newTestCA(t)}
+	handler := findHandler(t, NewAPIHandler(h), "GET", "/ssh-ca")
+
+	req := httptest.NewRequest("GET", "/ssh-ca", nil)
+	rec := httptest.NewRecorder()
+	handler.Handle(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp SSHCAResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.PublicKeys) != 1 {
+		t.Fatalf("expected one public key, got %d", len(resp.PublicKeys))
+	}
+}
+
+func TestJWKSIsRoutedOverHTTP(t *testing.T) {
+	h := &Handler{JWTIssuer: newTestIssuer(t)}
+	handler := findHandler(t, NewAPIHandler(h), "GET", "/.well-known/jwks.json")
+
+	req := httptest.NewRequest("GET", "/.well-known/jwks.json", nil)
+	rec := httptest.NewRecorder()
+	handler.Handle(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Body.Len() == 0 {
+		t.Fatal("expected a non-empty JWKS response")
+	}
+}
@@ -53,6 +53,25 @@ func opForRequest(r interface{}) bakery.Op {
 		return auth.UserOp(r.Username, auth.ActionWriteAdmin)
 	case *params.DischargeTokenForUserRequest:
 		return auth.GlobalOp(auth.ActionDischargeFor)
+	case *DischargeTokenForUserRequest:
+		return auth.GlobalOp(auth.ActionDischargeFor)
+	case *JWKSRequest:
+		// The JWKS is public key material, published so that
+		// relying parties can verify JWT discharge tokens; any
+		// holder of a login macaroon may read it.
+		return identchecker.LoginOp
+	case *BatchSetUsersRequest:
+		// Each entry is authorized independently by Handler against
+		// the affected user, rather than all-or-nothing here.
+		return identchecker.LoginOp
+	case *BatchModifyUserGroupsRequest:
+		return identchecker.LoginOp
+	case *BatchQueryUsersRequest:
+		return identchecker.LoginOp
+	case *SignSSHRequest:
+		return auth.UserOp(r.Username, auth.ActionSignSSH)
+	case *SSHCARequest:
+		return auth.GlobalOp(auth.ActionRead)
 	default:
 		logger.Infof("unknown API argument type %#v", r)
 	}
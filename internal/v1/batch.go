@@ -0,0 +1,86 @@
+// Copyright 2017 Canonical Ltd.
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gopkg.in/httprequest.v1"
+	"gopkg.in/juju/idmclient.v1/params"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+
+	"github.com/CanonicalLtd/blues-identity/internal/auth"
+)
+
+// BatchSetUsersRequest is the request sent to batch-create or
+// batch-update many users in a single call, rather than one
+// SetUserRequest per user.
+type BatchSetUsersRequest struct {
+	httprequest.Route `httprequest:"POST /batch/users"`
+	Users             []params.User `httprequest:",body"`
+}
+
+// BatchModifyUserGroupsEntry is a single entry of a
+// BatchModifyUserGroupsRequest, naming the groups that replace one
+// user's group membership.
+type BatchModifyUserGroupsEntry struct {
+	Username params.Username `json:"username"`
+	Groups   params.Groups   `json:"groups"`
+}
+
+// BatchModifyUserGroupsRequest is the request sent to modify the
+// group membership of many users in a single call.
+type BatchModifyUserGroupsRequest struct {
+	httprequest.Route `httprequest:"POST /batch/users/groups"`
+	Entries           []BatchModifyUserGroupsEntry `httprequest:",body"`
+}
+
+// BatchQueryUsersRequest is the request sent to fetch the details of
+// many users in a single call.
+type BatchQueryUsersRequest struct {
+	httprequest.Route `httprequest:"GET /batch/users"`
+	Usernames         []params.Username `httprequest:"username,form"`
+}
+
+// batchResult is one line of the NDJSON stream returned by a batch
+// endpoint, reporting the outcome for a single user so that a caller
+// with partial permissions can see exactly which entries succeeded
+// and which were refused, instead of the whole batch failing because
+// of one unauthorized or invalid entry.
+type batchResult struct {
+	Username params.Username `json:"username"`
+	User     *params.User    `json:"user,omitempty"`
+	Error    *params.Error   `json:"error,omitempty"`
+}
+
+// batchOps returns the per-user operation that must be authorized for
+// each of the given usernames, paired with the username it applies
+// to, so that a batch handler can authorize and apply every entry
+// independently rather than all-or-nothing.
+func batchOps(usernames []params.Username, action string) ([]bakery.Op, []params.Username) {
+	ops := make([]bakery.Op, len(usernames))
+	for i, u := range usernames {
+		ops[i] = auth.UserOp(u, action)
+	}
+	return ops, usernames
+}
+
+// writeBatchResults writes results to w as newline-delimited JSON,
+// one object per entry, flushing after each line. The batch handlers
+// build the complete results slice before calling it, so no line is
+// sent until every entry has been processed.
+func writeBatchResults(w http.ResponseWriter, results []batchResult) error {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	for _, r := range results {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2017 Canonical Ltd.
+
+package v1
+
+import (
+	"context"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+	"gopkg.in/juju/idmclient.v1/params"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
+
+	"github.com/CanonicalLtd/blues-identity/internal/auth"
+	"github.com/CanonicalLtd/blues-identity/internal/jwtissuer"
+	"github.com/CanonicalLtd/blues-identity/internal/sshca"
+)
+
+// UserStore is the subset of the identity manager's storage layer
+// needed to serve the batch and SSH certificate authority endpoints.
+type UserStore interface {
+	// SetUser creates or updates u.
+	SetUser(u *params.User) error
+
+	// User returns the details of the named user, including their
+	// registered SSH keys and group membership.
+	User(username params.Username) (*params.User, error)
+
+	// ModifyUserGroups replaces the named user's group membership
+	// with groups.
+	ModifyUserGroups(username params.Username, groups params.Groups) error
+}
+
+// Checker authorizes the operations required to serve a request.
+// *identchecker.AuthChecker satisfies this interface; handlers depend
+// on it rather than on *identchecker.AuthChecker directly so that
+// tests can exercise per-entry authorization with a fake.
+type Checker interface {
+	// AllowAny checks which of ops the current client is authorized
+	// for, without failing the whole call when only some of them
+	// are authorized, so that a batch handler can apply each entry
+	// independently.
+	AllowAny(ctx context.Context, ops ...bakery.Op) ([]bool, identchecker.AuthInfo, error)
+}
+
+// Handler implements the v1 API endpoints that need direct access to
+// the identity manager's store and authorization checker, rather than
+// being satisfiable from their request's fields alone.
+type Handler struct {
+	Store   UserStore
+	Checker Checker
+
+	// SSHCA signs SSH user certificates for the SignSSH and SSHCA
+	// endpoints. It is nil when no SSH certificate authority has
+	// been configured, in which case those endpoints are unavailable.
+	SSHCA *sshca.CA
+
+	// JWTIssuer signs JWT discharge tokens for DischargeTokenForUser
+	// requests with Format set to DischargeTokenFormatJWT, and
+	// publishes the JWKS for JWKS requests. It is nil when no JWT
+	// issuer has been configured.
+	JWTIssuer *jwtissuer.Issuer
+}
+
+// BatchSetUsers creates or updates every user in r.Users, authorizing
+// and applying each entry independently so that a caller with rights
+// over only some of the users sees the rest reported as forbidden
+// rather than the whole batch being refused.
+func (h *Handler) BatchSetUsers(p httprequest.Params, r *BatchSetUsersRequest) error {
+	usernames := make([]params.Username, len(r.Users))
+	for i, u := range r.Users {
+		usernames[i] = u.Username
+	}
+	ops, _ := batchOps(usernames, auth.ActionWriteAdmin)
+	allowed, _, err := h.Checker.AllowAny(p.Context, ops...)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	results := make([]batchResult, len(r.Users))
+	for i := range r.Users {
+		u := r.Users[i]
+		results[i].Username = u.Username
+		if !allowed[i] {
+			results[i].Error = forbiddenError
+			continue
+		}
+		if err := h.Store.SetUser(&u); err != nil {
+			results[i].Error = &params.Error{Message: err.Error()}
+		}
+	}
+	return writeBatchResults(p.Response, results)
+}
+
+// BatchModifyUserGroups replaces the group membership of every user
+// named in r.Entries, authorizing and applying each entry
+// independently.
+func (h *Handler) BatchModifyUserGroups(p httprequest.Params, r *BatchModifyUserGroupsRequest) error {
+	usernames := make([]params.Username, len(r.Entries))
+	for i, e := range r.Entries {
+		usernames[i] = e.Username
+	}
+	ops, _ := batchOps(usernames, auth.ActionWriteGroups)
+	allowed, _, err := h.Checker.AllowAny(p.Context, ops...)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	results := make([]batchResult, len(r.Entries))
+	for i, e := range r.Entries {
+		results[i].Username = e.Username
+		if !allowed[i] {
+			results[i].Error = forbiddenError
+			continue
+		}
+		if err := h.Store.ModifyUserGroups(e.Username, e.Groups); err != nil {
+			results[i].Error = &params.Error{Message: err.Error()}
+		}
+	}
+	return writeBatchResults(p.Response, results)
+}
+
+// BatchQueryUsers returns the details of every user named in
+// r.Usernames, authorizing and fetching each entry independently.
+func (h *Handler) BatchQueryUsers(p httprequest.Params, r *BatchQueryUsersRequest) error {
+	ops, usernames := batchOps(r.Usernames, auth.ActionRead)
+	allowed, _, err := h.Checker.AllowAny(p.Context, ops...)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	results := make([]batchResult, len(usernames))
+	for i, uname := range usernames {
+		results[i].Username = uname
+		if !allowed[i] {
+			results[i].Error = forbiddenError
+			continue
+		}
+		u, err := h.Store.User(uname)
+		if err != nil {
+			results[i].Error = &params.Error{Message: err.Error()}
+			continue
+		}
+		results[i].User = u
+	}
+	return writeBatchResults(p.Response, results)
+}
+
+// forbiddenError is the error reported for a batch entry that the
+// caller is not authorized to access.
+var forbiddenError = &params.Error{
+	Code:    params.ErrForbidden,
+	Message: "permission denied",
+}
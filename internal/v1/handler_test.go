@@ -0,0 +1,142 @@
+// Copyright 2017 Canonical Ltd.
+
+package v1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+	"gopkg.in/juju/idmclient.v1/params"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
+)
+
+// fakeStore is a UserStore that records the calls made to it, for use
+// in tests that don't need a real storage backend.
+type fakeStore struct {
+	users map[params.Username]*params.User
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{users: make(map[params.Username]*params.User)}
+}
+
+func (s *fakeStore) SetUser(u *params.User) error {
+	s.users[u.Username] = u
+	return nil
+}
+
+func (s *fakeStore) User(username params.Username) (*params.User, error) {
+	u, ok := s.users[username]
+	if !ok {
+		return nil, errNotFound
+	}
+	return u, nil
+}
+
+func (s *fakeStore) ModifyUserGroups(username params.Username, groups params.Groups) error {
+	u, ok := s.users[username]
+	if !ok {
+		return errNotFound
+	}
+	u.Groups = groups
+	return nil
+}
+
+var errNotFound = errgo.New("not found")
+
+// fakeChecker is a Checker that authorizes operations by entity name,
+// so that tests can exercise the per-entry allow/deny split without a
+// real bakery checker.
+type fakeChecker struct {
+	allowedEntities map[string]bool
+}
+
+func (c *fakeChecker) AllowAny(ctx context.Context, ops ...bakery.Op) ([]bool, identchecker.AuthInfo, error) {
+	allowed := make([]bool, len(ops))
+	for i, op := range ops {
+		allowed[i] = c.allowedEntities[op.Entity]
+	}
+	return allowed, identchecker.AuthInfo{}, nil
+}
+
+func readBatchResults(t *testing.T, body *bytes.Buffer) []batchResult {
+	t.Helper()
+	var results []batchResult
+	dec := json.NewDecoder(body)
+	for dec.More() {
+		var r batchResult
+		if err := dec.Decode(&r); err != nil {
+			t.Fatalf("cannot decode batch result: %v", err)
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+func TestBatchSetUsersAuthorizesEachEntry(t *testing.T) {
+	h := &Handler{
+		Store: newFakeStore(),
+		Checker: &fakeChecker{allowedEntities: map[string]bool{
+			"u-alice": true,
+		}},
+	}
+	rec := httptest.NewRecorder()
+	req := &BatchSetUsersRequest{
+		Users: []params.User{
+			{Username: "alice"},
+			{Username: "bob"},
+		},
+	}
+	if err := h.BatchSetUsers(httprequest.Params{Response: rec, Context: context.Background()}, req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	results := readBatchResults(t, rec.Body)
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	if results[0].Username != "alice" || results[0].Error != nil {
+		t.Fatalf("alice's entry should have succeeded: %+v", results[0])
+	}
+	if results[1].Username != "bob" || results[1].Error == nil {
+		t.Fatalf("bob's entry should have been forbidden: %+v", results[1])
+	}
+	if _, err := h.Store.(*fakeStore).User("alice"); err != nil {
+		t.Fatalf("alice should have been created: %v", err)
+	}
+	if _, err := h.Store.(*fakeStore).User("bob"); err == nil {
+		t.Fatalf("bob should not have been created")
+	}
+}
+
+func TestBatchQueryUsersAuthorizesEachEntry(t *testing.T) {
+	store := newFakeStore()
+	store.users["alice"] = &params.User{Username: "alice"}
+	store.users["bob"] = &params.User{Username: "bob"}
+	h := &Handler{
+		Store: store,
+		Checker: &fakeChecker{allowedEntities: map[string]bool{
+			"u-alice": true,
+		}},
+	}
+	rec := httptest.NewRecorder()
+	req := &BatchQueryUsersRequest{Usernames: []params.Username{"alice", "bob"}}
+	if err := h.BatchQueryUsers(httprequest.Params{Response: rec, Context: context.Background()}, req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	results := readBatchResults(t, rec.Body)
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	if results[0].User == nil {
+		t.Fatalf("alice's entry should have returned a user: %+v", results[0])
+	}
+	if results[1].Error == nil {
+		t.Fatalf("bob's entry should have been forbidden: %+v", results[1])
+	}
+}
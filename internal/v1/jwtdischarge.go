@@ -0,0 +1,56 @@
+// Copyright 2017 Canonical Ltd.
+
+package v1
+
+import (
+	"gopkg.in/httprequest.v1"
+	"gopkg.in/juju/idmclient.v1/params"
+)
+
+// DischargeTokenFormat names the format of a discharge token returned
+// by DischargeTokenForUserRequest.
+type DischargeTokenFormat string
+
+const (
+	// DischargeTokenFormatMacaroon is the default: a macaroon
+	// discharge, as returned by the upstream
+	// params.DischargeTokenForUserRequest.
+	DischargeTokenFormatMacaroon DischargeTokenFormat = "macaroon"
+
+	// DischargeTokenFormatJWT is an RS256-signed JWT whose claims
+	// identify the user, for relying parties that understand
+	// OIDC/JWT tokens but not macaroons.
+	DischargeTokenFormatJWT DischargeTokenFormat = "jwt"
+)
+
+// DischargeTokenForUserRequest extends the upstream
+// params.DischargeTokenForUserRequest with a Format field, so that a
+// caller holding the discharge-for permission can request a JWT
+// instead of a macaroon discharge. It is routed at a path of its own,
+// distinct from the upstream params.DischargeTokenForUserRequest's
+// "/discharge-token-for-user": the two are handled by separate
+// methods (DischargeTokenForUser here only ever issues a JWT; the
+// existing macaroon discharge endpoint is unaffected), and since they
+// are different Go types, registering both under the same Method and
+// Path would otherwise conflict in the route table.
+type DischargeTokenForUserRequest struct {
+	httprequest.Route `httprequest:"GET /discharge-token-for-user/jwt"`
+	params.DischargeTokenForUserRequest
+	Format   DischargeTokenFormat `httprequest:"format,form"`
+	Audience string               `httprequest:"audience,form"`
+}
+
+// DischargeTokenForUserResponse is the response to a
+// DischargeTokenForUserRequest with Format set to
+// DischargeTokenFormatJWT.
+type DischargeTokenForUserResponse struct {
+	Token string `json:"token"`
+}
+
+// JWKSRequest is the request sent to fetch Candid's current JWT
+// signing keys, published at /.well-known/jwks.json so that relying
+// parties can verify JWT discharge tokens without contacting Candid
+// on every request.
+type JWKSRequest struct {
+	httprequest.Route `httprequest:"GET /.well-known/jwks.json"`
+}
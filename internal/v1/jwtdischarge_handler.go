@@ -0,0 +1,54 @@
+// Copyright 2017 Canonical Ltd.
+
+package v1
+
+import (
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+)
+
+// dischargeTokenTTL bounds how long a JWT discharge token remains
+// valid for.
+const dischargeTokenTTL = time.Hour
+
+// DischargeTokenForUser issues a JWT discharge token for r.Username
+// when r.Format is DischargeTokenFormatJWT; requests for the default
+// macaroon format are handled by the existing discharge endpoint and
+// never reach this method.
+func (h *Handler) DischargeTokenForUser(r *DischargeTokenForUserRequest) (*DischargeTokenForUserResponse, error) {
+	if r.Format != DischargeTokenFormatJWT {
+		return nil, errgo.Newf("unsupported discharge token format %q; omit format for a macaroon discharge", r.Format)
+	}
+	if h.JWTIssuer == nil {
+		return nil, errgo.Newf("no JWT issuer is configured")
+	}
+	if err := h.JWTIssuer.MaybeRotate(); err != nil {
+		return nil, errgo.Notef(err, "cannot rotate JWT signing key")
+	}
+	u, err := h.Store.User(r.Username)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get user %q", r.Username)
+	}
+	token, err := h.JWTIssuer.Sign(string(r.Username), r.Audience, u.Groups, dischargeTokenTTL)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot sign discharge token")
+	}
+	return &DischargeTokenForUserResponse{Token: token}, nil
+}
+
+// JWKS writes the current JWT signing keys as a JSON Web Key Set, for
+// relying parties that verify JWT discharge tokens themselves.
+func (h *Handler) JWKS(p httprequest.Params, r *JWKSRequest) error {
+	if h.JWTIssuer == nil {
+		return errgo.Newf("no JWT issuer is configured")
+	}
+	data, err := h.JWTIssuer.JWKS()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	p.Response.Header().Set("Content-Type", "application/json")
+	_, err = p.Response.Write(data)
+	return err
+}
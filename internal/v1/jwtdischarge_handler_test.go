@@ -0,0 +1,58 @@
+// Copyright 2017 Canonical Ltd.
+
+package v1
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"gopkg.in/httprequest.v1"
+	"gopkg.in/juju/idmclient.v1/params"
+
+	"github.com/CanonicalLtd/blues-identity/internal/jwtissuer"
+)
+
+func newTestIssuer(t *testing.T) *jwtissuer.Issuer {
+	t.Helper()
+	iss, err := jwtissuer.NewIssuer(jwtissuer.Params{IssuerURL: "https://candid.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return iss
+}
+
+func TestDischargeTokenForUserIssuesJWT(t *testing.T) {
+	store := newFakeStore()
+	store.users["alice"] = &params.User{Username: "alice", Groups: []string{"admins"}}
+	h := &Handler{Store: store, JWTIssuer: newTestIssuer(t)}
+
+	req := &DischargeTokenForUserRequest{Format: DischargeTokenFormatJWT, Audience: "myapp"}
+	req.Username = "alice"
+	resp, err := h.DischargeTokenForUser(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Token == "" {
+		t.Fatal("expected a non-empty token")
+	}
+}
+
+func TestDischargeTokenForUserRejectsMacaroonFormat(t *testing.T) {
+	h := &Handler{JWTIssuer: newTestIssuer(t)}
+	req := &DischargeTokenForUserRequest{}
+	req.Username = "alice"
+	if _, err := h.DischargeTokenForUser(req); err == nil {
+		t.Fatal("expected an error for the default (macaroon) format")
+	}
+}
+
+func TestJWKSWritesTheCurrentKeySet(t *testing.T) {
+	h := &Handler{JWTIssuer: newTestIssuer(t)}
+	rec := httptest.NewRecorder()
+	if err := h.JWKS(httprequest.Params{Response: rec}, &JWKSRequest{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rec.Body.Len() == 0 {
+		t.Fatal("expected a non-empty JWKS response")
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 Canonical Ltd.
+
+package v1
+
+import (
+	"gopkg.in/httprequest.v1"
+	"gopkg.in/juju/idmclient.v1/params"
+)
+
+// SignSSHRequest is the request sent to sign an OpenSSH user
+// certificate for one of the caller's registered SSH keys. It builds
+// on the SSHKeys/PutSSHKeys endpoints: the key being certified must
+// already be registered against Username.
+type SignSSHRequest struct {
+	httprequest.Route `httprequest:"POST /u/:user/ssh-cert"`
+	Username          params.Username `httprequest:"user,path"`
+	Body              SignSSHBody     `httprequest:",body"`
+}
+
+// SignSSHBody is the body of a SignSSHRequest.
+type SignSSHBody struct {
+	// PublicKey is the public key to certify, in OpenSSH
+	// "authorized_keys" format. It must be one of Username's
+	// registered SSH keys.
+	PublicKey string `json:"public_key"`
+
+	// Principals lists the principals the caller is requesting for
+	// the certificate. The permitted principals are Username itself
+	// and the names of Username's Candid groups; the issued
+	// certificate's principals are the intersection of this list
+	// with them, or all of them when this list is empty.
+	Principals []string `json:"principals,omitempty"`
+
+	// ValiditySeconds is how long the certificate should remain
+	// valid for, capped by the CA's configured maximum validity.
+	ValiditySeconds int64 `json:"validity_seconds,omitempty"`
+}
+
+// SignSSHResponse holds a freshly issued SSH user certificate.
+type SignSSHResponse struct {
+	Certificate string `json:"certificate"`
+}
+
+// SSHCARequest is the request sent to fetch the CA's public keys, so
+// that they can be installed in a server's TrustedUserCAKeys file.
+type SSHCARequest struct {
+	httprequest.Route `httprequest:"GET /ssh-ca"`
+}
+
+// SSHCAResponse holds the CA's public keys, in "authorized_keys"
+// format, one per line.
+type SSHCAResponse struct {
+	PublicKeys []string `json:"public_keys"`
+}
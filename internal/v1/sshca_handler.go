@@ -0,0 +1,74 @@
+// Copyright 2017 Canonical Ltd.
+
+package v1
+
+import (
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// SignSSH issues an SSH user certificate for one of r.Username's
+// registered SSH keys, restricted to the intersection of
+// r.Body.Principals with Username and the names of Username's Candid
+// groups, as documented on SignSSHBody.
+func (h *Handler) SignSSH(r *SignSSHRequest) (*SignSSHResponse, error) {
+	if h.SSHCA == nil {
+		return nil, errgo.Newf("no SSH certificate authority is configured")
+	}
+	u, err := h.Store.User(r.Username)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get user %q", r.Username)
+	}
+	if !hasSSHKey(u.SSHKeys, r.Body.PublicKey) {
+		return nil, errgo.Newf("public key is not registered for %q", r.Username)
+	}
+	derived := append([]string{string(r.Username)}, u.Groups...)
+	principals := derived
+	if len(r.Body.Principals) > 0 {
+		principals = intersectPrincipals(r.Body.Principals, derived)
+		if len(principals) == 0 {
+			return nil, errgo.Newf("none of the requested principals are permitted for %q", r.Username)
+		}
+	}
+	cert, err := h.SSHCA.Sign(r.Body.PublicKey, principals, u.Groups, time.Duration(r.Body.ValiditySeconds)*time.Second)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot sign SSH certificate")
+	}
+	return &SignSSHResponse{Certificate: cert}, nil
+}
+
+// SSHCAKeys returns the SSH certificate authority's public keys, for
+// installation in a server's TrustedUserCAKeys file.
+func (h *Handler) SSHCAKeys(r *SSHCARequest) (*SSHCAResponse, error) {
+	if h.SSHCA == nil {
+		return nil, errgo.Newf("no SSH certificate authority is configured")
+	}
+	return &SSHCAResponse{PublicKeys: []string{h.SSHCA.PublicKey()}}, nil
+}
+
+// hasSSHKey reports whether key is one of the keys in keys.
+func hasSSHKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// intersectPrincipals returns the principals in requested that are
+// also present in allowed, preserving requested's order.
+func intersectPrincipals(requested, allowed []string) []string {
+	allowedSet := make(map[string]bool, len(allowed))
+	for _, a := range allowed {
+		allowedSet[a] = true
+	}
+	var result []string
+	for _, r := range requested {
+		if allowedSet[r] {
+			result = append(result, r)
+		}
+	}
+	return result
+}
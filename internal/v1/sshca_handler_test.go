@@ -0,0 +1,117 @@
+// Copyright 2017 Canonical Ltd.
+
+package v1
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/juju/idmclient.v1/params"
+
+	"github.com/CanonicalLtd/blues-identity/internal/sshca"
+)
+
+// newTestCA writes a freshly generated PEM-encoded SSH private key to
+// a file under t.TempDir and returns the *sshca.CA that signs with it.
+func newTestCA(t *testing.T) *sshca.CA {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}
+	path := filepath.Join(t.TempDir(), "ca_key")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ca, err := sshca.New(sshca.Params{PrivateKeyPath: path})
+	if err != nil {
+		t.Fatalf("cannot create CA: %v", err)
+	}
+	return ca
+}
+
+func newTestAuthorizedKey(t *testing.T) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(ssh.MarshalAuthorizedKey(pub))
+}
+
+func TestSignSSHRestrictsPrincipalsToGroupIntersection(t *testing.T) {
+	authorizedKey := newTestAuthorizedKey(t)
+	store := newFakeStore()
+	store.users["alice"] = &params.User{
+		Username: "alice",
+		SSHKeys:  []string{authorizedKey},
+		Groups:   []string{"ops", "dev"},
+	}
+	h := &Handler{Store: store, SSHCA: newTestCA(t)}
+
+	resp, err := h.SignSSH(&SignSSHRequest{
+		Username: "alice",
+		Body: SignSSHBody{
+			PublicKey:  authorizedKey,
+			Principals: []string{"ops", "nonmember"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cert, _, _, _, err := ssh.ParseAuthorizedKey([]byte(resp.Certificate))
+	if err != nil {
+		t.Fatalf("cannot parse issued certificate: %v", err)
+	}
+	sshCert, ok := cert.(*ssh.Certificate)
+	if !ok {
+		t.Fatalf("expected an ssh.Certificate, got %T", cert)
+	}
+	if want := []string{"ops"}; !equalStrings(sshCert.ValidPrincipals, want) {
+		t.Fatalf("got principals %v, want %v", sshCert.ValidPrincipals, want)
+	}
+}
+
+func TestSignSSHRejectsUnregisteredKey(t *testing.T) {
+	store := newFakeStore()
+	store.users["alice"] = &params.User{
+		Username: "alice",
+		SSHKeys:  []string{newTestAuthorizedKey(t)},
+		Groups:   []string{"dev"},
+	}
+	h := &Handler{Store: store, SSHCA: newTestCA(t)}
+
+	_, err := h.SignSSH(&SignSSHRequest{
+		Username: "alice",
+		Body:     SignSSHBody{PublicKey: newTestAuthorizedKey(t)},
+	})
+	if err == nil {
+		t.Fatal("expected an error for an unregistered public key")
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}